@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ColorMapper decides the fill color for a single drawn arc. level is the
+// ring the arc is drawn on (curve.level: 1-based, counted outward across all
+// loops, not restarted per loop), totalLevels is the overall level count the
+// image was sized for, loopIdx is the index of the top-level loop the node's
+// subtree hangs off of, and subtreeSize is the number of nodes in node's
+// subtree, including node itself; for a loop member the other members of its
+// loop are not counted.
+type ColorMapper interface {
+	Color(node int32, level, totalLevels, loopIdx, subtreeSize int) color.RGBA
+}
+
+// IdentityMapper is the original behavior: the node's own number, taken as
+// a packed 0xRRGGBB value, is used directly as its fill color.
+type IdentityMapper struct{}
+
+func (IdentityMapper) Color(node int32, level, totalLevels, loopIdx, subtreeSize int) color.RGBA {
+	return color.RGBA{
+		R: byte(node >> 16),
+		G: byte(node >> 8),
+		B: byte(node),
+		A: 0xFF,
+	}
+}
+
+// HSLByLevelMapper hues by level and lightens toward the edge of the
+// image, matching the usual sunburst-chart convention of hue-per-ring.
+type HSLByLevelMapper struct{}
+
+func (HSLByLevelMapper) Color(node int32, level, totalLevels, loopIdx, subtreeSize int) color.RGBA {
+	frac := float64(level) / float64(totalLevels)
+	return hslToRGBA(frac, 0.65, 0.35+0.45*frac)
+}
+
+// LoopMapper gives each top-level loop its own hue, with saturation driven
+// by subtree size: nodes with more descendants read as more saturated than
+// sparse leaves. MaxSubtreeSize should be the largest subtreeSize the mapper
+// will see (typically the root graph's node count) so saturation normalizes
+// against it; sizes are log-scaled first since one loop's subtree usually
+// dwarfs the rest.
+type LoopMapper struct {
+	TotalLoops     int
+	MaxSubtreeSize int
+}
+
+func (m LoopMapper) Color(node int32, level, totalLevels, loopIdx, subtreeSize int) color.RGBA {
+	n := m.TotalLoops
+	if n < 1 {
+		n = 1
+	}
+	hue := float64(loopIdx) / float64(n)
+
+	max := m.MaxSubtreeSize
+	if max < 1 {
+		max = 1
+	}
+	frac := math.Log1p(float64(subtreeSize)) / math.Log1p(float64(max))
+	saturation := 0.4 + 0.5*frac
+	return hslToRGBA(hue, saturation, 0.55)
+}
+
+// hslToRGBA converts HSL (each component in [0,1]) to an opaque color.RGBA.
+func hslToRGBA(h, s, l float64) color.RGBA {
+	if s == 0 {
+		v := byte(l * 255)
+		return color.RGBA{R: v, G: v, B: v, A: 0xFF}
+	}
+
+	var q float64
+	if l < 0.5 {
+		q = l * (1 + s)
+	} else {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+
+	return color.RGBA{
+		R: byte(hueToChannel(p, q, h+1.0/3) * 255),
+		G: byte(hueToChannel(p, q, h) * 255),
+		B: byte(hueToChannel(p, q, h-1.0/3) * 255),
+		A: 0xFF,
+	}
+}
+
+func hueToChannel(p, q, t float64) float64 {
+	if t < 0 {
+		t++
+	}
+	if t > 1 {
+		t--
+	}
+	switch {
+	case t < 1.0/6:
+		return p + (q-p)*6*t
+	case t < 1.0/2:
+		return q
+	case t < 2.0/3:
+		return p + (q-p)*(2.0/3-t)*6
+	default:
+		return p
+	}
+}
+
+// GradientStop is one control point in a GradientMapper: At is the
+// fractional position (0-1) along the gradient, Color is the RGBA at that
+// point.
+type GradientStop struct {
+	At    float64
+	Color color.RGBA
+}
+
+// GradientMapper maps level/totalLevels onto a position along a list of
+// stops and linearly interpolates between the two it falls between.
+type GradientMapper struct {
+	Stops []GradientStop
+}
+
+func (m GradientMapper) Color(node int32, level, totalLevels, loopIdx, subtreeSize int) color.RGBA {
+	if len(m.Stops) == 0 {
+		return color.RGBA{A: 0xFF}
+	}
+
+	t := float64(level) / float64(totalLevels)
+	stops := m.Stops
+	if t <= stops[0].At {
+		return stops[0].Color
+	}
+	last := stops[len(stops)-1]
+	if t >= last.At {
+		return last.Color
+	}
+
+	for i := 1; i < len(stops); i++ {
+		if t > stops[i].At {
+			continue
+		}
+		prev := stops[i-1]
+		frac := 0.0
+		if span := stops[i].At - prev.At; span > 0 {
+			frac = (t - prev.At) / span
+		}
+		return lerpRGBA(prev.Color, stops[i].Color, frac)
+	}
+	return last.Color
+}
+
+func lerpRGBA(a, b color.RGBA, t float64) color.RGBA {
+	lerp := func(x, y uint8) uint8 {
+		return uint8(float64(x) + (float64(y)-float64(x))*t)
+	}
+	return color.RGBA{
+		R: lerp(a.R, b.R),
+		G: lerp(a.G, b.G),
+		B: lerp(a.B, b.B),
+		A: lerp(a.A, b.A),
+	}
+}
+
+type jsonGradientStop struct {
+	At    float64 `json:"at"`
+	Color string  `json:"color"`
+}
+
+// LoadGradientMapperJSON reads a GradientMapper from a JSON file: a list of
+// {"at": 0-1, "color": "#RRGGBB"} stops.
+func LoadGradientMapperJSON(path string) (GradientMapper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return GradientMapper{}, err
+	}
+
+	var raw []jsonGradientStop
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return GradientMapper{}, err
+	}
+
+	stops := make([]GradientStop, len(raw))
+	for i, s := range raw {
+		c, err := parseHexColor(s.Color)
+		if err != nil {
+			return GradientMapper{}, fmt.Errorf("stop %d: %w", i, err)
+		}
+		stops[i] = GradientStop{At: s.At, Color: c}
+	}
+	return GradientMapper{Stops: stops}, nil
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("color %q must be 6 hex digits", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return color.RGBA{R: byte(v >> 16), G: byte(v >> 8), B: byte(v), A: 0xFF}, nil
+}
+
+// LoadGradientMapperGGR reads a GradientMapper from a GIMP gradient (.ggr)
+// file. Each segment contributes one stop at its left endpoint, and the
+// last segment a second one at its right endpoint; the right endpoint color
+// of every other segment is not used. The midpoint and blend/color-type
+// fields GIMP uses for interpolation within a segment are ignored, since
+// GradientMapper only interpolates linearly between stops.
+func LoadGradientMapperGGR(path string) (GradientMapper, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return GradientMapper{}, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) != "GIMP Gradient" {
+		return GradientMapper{}, fmt.Errorf("not a GIMP gradient file")
+	}
+	if !sc.Scan() { // "Name: ..." line
+		return GradientMapper{}, fmt.Errorf("truncated GIMP gradient file")
+	}
+	if !sc.Scan() {
+		return GradientMapper{}, fmt.Errorf("truncated GIMP gradient file")
+	}
+	numSegs, err := strconv.Atoi(strings.TrimSpace(sc.Text()))
+	if err != nil {
+		return GradientMapper{}, fmt.Errorf("bad segment count: %w", err)
+	}
+
+	var stops []GradientStop
+	for i := 0; i < numSegs; i++ {
+		if !sc.Scan() {
+			return GradientMapper{}, fmt.Errorf("truncated GIMP gradient file")
+		}
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 11 {
+			return GradientMapper{}, fmt.Errorf("malformed gradient segment %q", sc.Text())
+		}
+
+		leftPos, _ := strconv.ParseFloat(fields[0], 64)
+		rightPos, _ := strconv.ParseFloat(fields[2], 64)
+		leftColor, err := ggrColor(fields[3:7])
+		if err != nil {
+			return GradientMapper{}, err
+		}
+		rightColor, err := ggrColor(fields[7:11])
+		if err != nil {
+			return GradientMapper{}, err
+		}
+
+		stops = append(stops, GradientStop{At: leftPos, Color: leftColor})
+		if i == numSegs-1 {
+			stops = append(stops, GradientStop{At: rightPos, Color: rightColor})
+		}
+	}
+	return GradientMapper{Stops: stops}, sc.Err()
+}
+
+func ggrColor(fields []string) (color.RGBA, error) {
+	var vals [4]float64
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return color.RGBA{}, err
+		}
+		vals[i] = v
+	}
+	return color.RGBA{
+		R: byte(math.Round(vals[0] * 255)),
+		G: byte(math.Round(vals[1] * 255)),
+		B: byte(math.Round(vals[2] * 255)),
+		A: byte(math.Round(vals[3] * 255)),
+	}, nil
+}
+
+// loadColorMapper resolves the -palette flag into a ColorMapper: one of the
+// built-in names, or a gradient file path, picked by extension. totalNodes
+// is the graph's node count, used to normalize LoopMapper's subtree-size
+// saturation.
+func loadColorMapper(palette string, numLoops, totalNodes int) (ColorMapper, error) {
+	switch palette {
+	case "", "identity":
+		return IdentityMapper{}, nil
+	case "hsl":
+		return HSLByLevelMapper{}, nil
+	case "loop":
+		return LoopMapper{TotalLoops: numLoops, MaxSubtreeSize: totalNodes}, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(palette)) {
+	case ".ggr":
+		return LoadGradientMapperGGR(palette)
+	case ".json":
+		return LoadGradientMapperJSON(palette)
+	}
+	return nil, fmt.Errorf("unrecognized palette %q", palette)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHslToRGBA(t *testing.T) {
+	tests := []struct {
+		name    string
+		h, s, l float64
+		want    color.RGBA
+	}{
+		{"red", 0, 1, 0.5, color.RGBA{R: 255, G: 0, B: 0, A: 0xFF}},
+		{"green", 1.0 / 3, 1, 0.5, color.RGBA{R: 0, G: 255, B: 0, A: 0xFF}},
+		{"blue", 2.0 / 3, 1, 0.5, color.RGBA{R: 0, G: 0, B: 255, A: 0xFF}},
+		{"gray", 0, 0, 0.5, color.RGBA{R: 127, G: 127, B: 127, A: 0xFF}},
+	}
+	for _, tt := range tests {
+		if got := hslToRGBA(tt.h, tt.s, tt.l); got != tt.want {
+			t.Errorf("%s: hslToRGBA(%v, %v, %v) = %v, want %v", tt.name, tt.h, tt.s, tt.l, got, tt.want)
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	got, err := parseHexColor("#ff8000")
+	if err != nil {
+		t.Fatalf("parseHexColor: %v", err)
+	}
+	if want := (color.RGBA{R: 0xff, G: 0x80, B: 0x00, A: 0xFF}); got != want {
+		t.Errorf("parseHexColor(#ff8000) = %v, want %v", got, want)
+	}
+
+	if _, err := parseHexColor("#fff"); err == nil {
+		t.Error("expected an error for a non-6-digit color")
+	}
+}
+
+func TestLoadGradientMapperJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gradient.json")
+	const data = `[{"at":0,"color":"#000000"},{"at":1,"color":"#ffffff"}]`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadGradientMapperJSON(path)
+	if err != nil {
+		t.Fatalf("LoadGradientMapperJSON: %v", err)
+	}
+	if len(m.Stops) != 2 {
+		t.Fatalf("got %d stops, want 2", len(m.Stops))
+	}
+
+	// Halfway between black and white should land on mid-gray.
+	if got, want := m.Color(0, 1, 2, 0, 0), (color.RGBA{R: 127, G: 127, B: 127, A: 0xFF}); got != want {
+		t.Errorf("Color at midpoint = %v, want %v", got, want)
+	}
+}
+
+func TestLoadGradientMapperGGR(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gradient.ggr")
+	const data = "GIMP Gradient\n" +
+		"Name: test\n" +
+		"1\n" +
+		"0.000000 0.500000 1.000000 0.000000 0.000000 0.000000 1.000000 1.000000 1.000000 1.000000 1.000000\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadGradientMapperGGR(path)
+	if err != nil {
+		t.Fatalf("LoadGradientMapperGGR: %v", err)
+	}
+
+	want := []GradientStop{
+		{At: 0, Color: color.RGBA{A: 0xFF}},
+		{At: 1, Color: color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}},
+	}
+	if len(m.Stops) != len(want) {
+		t.Fatalf("got %d stops, want %d", len(m.Stops), len(want))
+	}
+	for i, s := range m.Stops {
+		if s != want[i] {
+			t.Errorf("stop %d = %v, want %v", i, s, want[i])
+		}
+	}
+}
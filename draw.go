@@ -1,27 +1,50 @@
 package main
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
 	"log"
 	"math"
-	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
+	"github.com/jung-kurt/gofpdf"
+	"github.com/llgcode/draw2d"
 	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dpdf"
+	"github.com/llgcode/draw2d/draw2dsvg"
 )
 
-type img struct {
+// img is implemented by each output backend (png, svg, pdf). All of them
+// share the same arc-drawing math in baseImg; they differ only in how the
+// underlying draw2d.GraphicContext is created and how the result is
+// persisted to disk.
+type img interface {
+	drawCurve(c curve)
+	save() error
+}
+
+type curve struct {
+	level      int
+	color      color.RGBA
+	start, end float64
+}
+
+// baseImg holds the geometry shared by every backend and draws curves
+// through whatever draw2d.GraphicContext the backend constructed. Backends
+// embed it to get drawCurve for free.
+type baseImg struct {
 	filename         string
 	w, h             int
 	centerX, centerY float64
 	levelWidth       int
-	rgba             *image.RGBA
-	ctx              *draw2dimg.GraphicContext
+	ctx              draw2d.GraphicContext
 }
 
-func newImg(filename string, w, h, levels int) img {
+func newBaseImg(filename string, w, h, levels int, ctx draw2d.GraphicContext) baseImg {
 	// Leave a 5% buffer on the sides so that the image doesn't cut right up to
 	// the edge
 	bw, bh := float64(w)*0.95, float64(h)*0.95
@@ -36,69 +59,228 @@ func newImg(filename string, w, h, levels int) img {
 		log.Fatalf("level width is too small! %f", levelWidth)
 	}
 
-	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
-	ctx := draw2dimg.NewGraphicContext(rgba)
-
-	return img{
+	return baseImg{
 		filename:   filename,
 		w:          w,
 		h:          h,
 		centerX:    float64(w) / 2,
 		centerY:    float64(h) / 2,
 		levelWidth: int(levelWidth),
-		rgba:       rgba,
 		ctx:        ctx,
 	}
 }
 
-type curve struct {
-	level      int
-	color      uint64
-	start, end float64
+func (i baseImg) drawCurve(c curve) {
+	drawCurveOnCtx(i.ctx, i.centerX, i.centerY, i.levelWidth, c)
 }
 
-func (i img) drawCurve(c curve) {
-	red := byte((c.color >> 16))
-	green := byte((c.color >> 8))
-	blue := byte(c.color)
-
+// drawCurveOnCtx is the arc-plus-fill math shared by every backend and every
+// tile: it only needs a GraphicContext and the center it should draw around,
+// so a tile can pass its own origin-shifted center to draw the same curve
+// into tile-local coordinates.
+func drawCurveOnCtx(ctx draw2d.GraphicContext, centerX, centerY float64, levelWidth int, c curve) {
 	startAngle := c.start * 2 * math.Pi
 	angle := (c.end - c.start) * 2 * math.Pi
 	endAngle := startAngle + angle
-	radius := float64(c.level * i.levelWidth)
-	radiusOuter := radius + float64(i.levelWidth)
-	i.ctx.SetStrokeColor(color.RGBA{0, 0, 0, 0})
-	i.ctx.SetFillColor(color.RGBA{red, green, blue, 0xFF})
-
-	i.ctx.MoveTo(
-		i.centerX+math.Cos(startAngle)*radius,
-		i.centerY+math.Sin(startAngle)*radius,
+	radius := float64(c.level * levelWidth)
+	radiusOuter := radius + float64(levelWidth)
+	ctx.SetStrokeColor(color.RGBA{0, 0, 0, 0})
+	ctx.SetFillColor(c.color)
+
+	ctx.MoveTo(
+		centerX+math.Cos(startAngle)*radius,
+		centerY+math.Sin(startAngle)*radius,
 	)
-	i.ctx.ArcTo(i.centerX, i.centerY, radius, radius, startAngle, angle)
+	ctx.ArcTo(centerX, centerY, radius, radius, startAngle, angle)
 
-	i.ctx.LineTo(
-		i.centerX+math.Cos(endAngle)*radiusOuter,
-		i.centerY+math.Sin(endAngle)*radiusOuter,
+	ctx.LineTo(
+		centerX+math.Cos(endAngle)*radiusOuter,
+		centerY+math.Sin(endAngle)*radiusOuter,
 	)
-	i.ctx.ArcTo(i.centerX, i.centerY, radiusOuter, radiusOuter, endAngle, -angle)
+	ctx.ArcTo(centerX, centerY, radiusOuter, radiusOuter, endAngle, -angle)
 
-	i.ctx.LineTo(
-		i.centerX+math.Cos(startAngle)*radius,
-		i.centerY+math.Sin(startAngle)*radius,
+	ctx.LineTo(
+		centerX+math.Cos(startAngle)*radius,
+		centerY+math.Sin(startAngle)*radius,
 	)
 
-	i.ctx.FillStroke()
+	ctx.FillStroke()
 }
 
-func (i img) save() error {
+// tiledImg is implemented by backends whose drawCurve is safe to call from
+// multiple goroutines at once, each against its own tile. The parallel
+// renderer in main.go uses drawCurveConcurrent instead of drawCurve when a
+// backend provides it, and falls back to a single worker otherwise, since an
+// svg/pdf document isn't safe for concurrent writers.
+type tiledImg interface {
+	img
+	drawCurveConcurrent(c curve)
+}
+
+// tileGridSize is the side length of the NxN grid pngImg renders into. Each
+// cell gets its own *image.RGBA and GraphicContext so workers never contend
+// on one shared canvas; save() composites them back together.
+const tileGridSize = 4
+
+// pngTile is one cell of that grid. A curve's bounding box can still
+// overlap more than one tile (outer rings sweep across most of the image),
+// and more than one loop's subtree can touch the same tile, so each tile
+// guards its own GraphicContext with a mutex.
+//
+// rgba is zero-based (Bounds().Min == (0,0)) regardless of where the tile
+// sits on the final canvas, since draw2d's rasterizer and image.RGBA.Set
+// both address pixels relative to the image's own Rect; absRect records the
+// tile's placement on that canvas for overlap tests and compositing in
+// save().
+type pngTile struct {
+	mu      sync.Mutex
+	absRect image.Rectangle
+	rgba    *image.RGBA
+	ctx     *draw2dimg.GraphicContext
+}
+
+func (t *pngTile) drawCurve(centerX, centerY float64, levelWidth int, c curve) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	drawCurveOnCtx(t.ctx, centerX-float64(t.absRect.Min.X), centerY-float64(t.absRect.Min.Y), levelWidth, c)
+}
+
+// pngImg rasterizes into an *image.RGBA, which is the only backend that
+// needs a white background composited in underneath (svg/pdf canvases are
+// transparent/white by default).
+type pngImg struct {
+	baseImg
+	rgba  *image.RGBA
+	tiles []*pngTile
+}
+
+func newPNGImg(filename string, w, h, levels int) *pngImg {
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	ctx := draw2dimg.NewGraphicContext(rgba)
+	return &pngImg{
+		baseImg: newBaseImg(filename, w, h, levels, ctx),
+		rgba:    rgba,
+		tiles:   newPNGTiles(w, h, tileGridSize),
+	}
+}
+
+func newPNGTiles(w, h, n int) []*pngTile {
+	tileW, tileH := w/n, h/n
+	tiles := make([]*pngTile, 0, n*n)
+	for col := 0; col < n; col++ {
+		for row := 0; row < n; row++ {
+			x0, y0 := col*tileW, row*tileH
+			x1, y1 := x0+tileW, y0+tileH
+			if col == n-1 {
+				x1 = w
+			}
+			if row == n-1 {
+				y1 = h
+			}
+
+			absRect := image.Rect(x0, y0, x1, y1)
+			trgba := image.NewRGBA(image.Rect(0, 0, absRect.Dx(), absRect.Dy()))
+			tiles = append(tiles, &pngTile{
+				absRect: absRect,
+				rgba:    trgba,
+				ctx:     draw2dimg.NewGraphicContext(trgba),
+			})
+		}
+	}
+	return tiles
+}
+
+// drawCurveConcurrent draws c into every tile its bounding box touches,
+// rather than into the single shared canvas drawCurve uses. It's safe to
+// call from any number of goroutines at once.
+func (i *pngImg) drawCurveConcurrent(c curve) {
+	radiusOuter := float64(c.level*i.levelWidth + i.levelWidth)
+	bbox := image.Rect(
+		int(i.centerX-radiusOuter), int(i.centerY-radiusOuter),
+		int(i.centerX+radiusOuter), int(i.centerY+radiusOuter),
+	)
+
+	for _, t := range i.tiles {
+		if t.absRect.Overlaps(bbox) {
+			t.drawCurve(i.centerX, i.centerY, i.levelWidth, c)
+		}
+	}
+}
+
+func (i *pngImg) save() error {
+	for _, t := range i.tiles {
+		draw.Draw(i.rgba, t.absRect, t.rgba, t.rgba.Bounds().Min, draw.Over)
+	}
+
 	back := image.NewRGBA(image.Rect(0, 0, i.w, i.h))
 	draw.Draw(back, back.Bounds(), &image.Uniform{color.White}, image.ZP, draw.Src)
 	draw.Draw(back, back.Bounds(), i.rgba, image.ZP, draw.Over)
 
-	f, err := os.Create(i.filename)
-	if err != nil {
-		return err
+	return draw2dimg.SaveToPngFile(i.filename, back)
+}
+
+// svgImg draws directly into a draw2dsvg.Svg tree, so each curve becomes its
+// own <path> rather than a few million rasterized pixels.
+type svgImg struct {
+	baseImg
+	svg *draw2dsvg.Svg
+}
+
+func newSVGImg(filename string, w, h, levels int) *svgImg {
+	svg := draw2dsvg.NewSvg()
+	svg.Width = fmt.Sprintf("%dpx", w)
+	svg.Height = fmt.Sprintf("%dpx", h)
+	ctx := draw2dsvg.NewGraphicContext(svg)
+	return &svgImg{
+		baseImg: newBaseImg(filename, w, h, levels, ctx),
+		svg:     svg,
+	}
+}
+
+func (i *svgImg) save() error {
+	return draw2dsvg.SaveToSvgFile(i.filename, i.svg)
+}
+
+// pdfImg draws into a gofpdf document via draw2dpdf, one page sized to the
+// requested pixel dimensions (treated as points, same as the "pt" unit
+// passed to gofpdf) rather than a fixed paper size.
+type pdfImg struct {
+	baseImg
+	pdf *gofpdf.Fpdf
+}
+
+func newPDFImg(filename string, w, h, levels int) *pdfImg {
+	orientationStr := "P"
+	if w > h {
+		orientationStr = "L"
+	}
+
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		OrientationStr: orientationStr,
+		UnitStr:        "pt",
+		Size:           gofpdf.SizeType{Wd: float64(w), Ht: float64(h)},
+	})
+	pdf.AddPage()
+	ctx := draw2dpdf.NewGraphicContext(pdf)
+	return &pdfImg{
+		baseImg: newBaseImg(filename, w, h, levels, ctx),
+		pdf:     pdf,
+	}
+}
+
+func (i *pdfImg) save() error {
+	return draw2dpdf.SaveToPdfFile(i.filename, i.pdf)
+}
+
+// newImg picks the output backend based on filename's extension, defaulting
+// to PNG for anything it doesn't recognize.
+func newImg(filename string, w, h, levels int) img {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".svg":
+		return newSVGImg(filename, w, h, levels)
+	case ".pdf":
+		return newPDFImg(filename, w, h, levels)
+	default:
+		return newPNGImg(filename, w, h, levels)
 	}
-	defer f.Close()
-	return png.Encode(f, back)
 }
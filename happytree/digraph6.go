@@ -0,0 +1,240 @@
+package happytree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// EncodeDigraph6 writes g in digraph6 format: header byte '&', then N(n)
+// giving the vertex count, then the adjacency matrix packed row-major into
+// 6-bit groups, each biased by 63. Every node in a Graph has exactly one
+// outgoing edge (its Dst), so the matrix is sparse but still written in
+// full, as digraph6 requires for directed graphs.
+func EncodeDigraph6(w io.Writer, g *Graph) error {
+	bw := bufio.NewWriter(w)
+	if err := bw.WriteByte('&'); err != nil {
+		return err
+	}
+	if err := writeDigraph6Size(bw, g.Len()); err != nil {
+		return err
+	}
+
+	bits := newBitWriter(bw)
+	n := g.Len()
+	for i := 0; i < n; i++ {
+		dst := g.Dst(int32(i))
+		for j := 0; j < n; j++ {
+			if err := bits.writeBit(int32(j) == dst); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bits.flush(); err != nil {
+		return err
+	}
+
+	if err := bw.WriteByte('\n'); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// DecodeDigraph6 decodes a digraph6-encoded graph. It requires every node
+// to have exactly one outgoing edge, since that's what Graph represents; a
+// row with zero or more than one set bit is an error.
+func DecodeDigraph6(r io.Reader) (*Graph, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if header != '&' {
+		return nil, fmt.Errorf("happytree: not a digraph6 stream (missing '&' header)")
+	}
+
+	n, err := readDigraph6Size(br)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Graph{
+		Size:  n,
+		nodes: make([]Node, n),
+		srcs:  make([]int32, n),
+	}
+	dsts := make([]int32, n)
+
+	bits := newBitReader(br)
+	for i := 0; i < n; i++ {
+		found := false
+		for j := 0; j < n; j++ {
+			set, err := bits.readBit()
+			if err != nil {
+				return nil, err
+			}
+			if !set {
+				continue
+			}
+			if found {
+				return nil, fmt.Errorf("happytree: node %d has more than one outgoing edge", i)
+			}
+			dsts[i] = int32(j)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("happytree: node %d has no outgoing edge", i)
+		}
+		g.nodes[i].Dst = dsts[i]
+		g.nodes[dsts[i]].srcLen++
+	}
+
+	g.cursor = make([]int32, n)
+	var off int32
+	for i := range g.nodes {
+		g.nodes[i].srcOff = off
+		g.cursor[i] = off
+		off += g.nodes[i].srcLen
+	}
+	for i := 0; i < n; i++ {
+		dst := dsts[i]
+		g.srcs[g.cursor[dst]] = int32(i)
+		g.cursor[dst]++
+	}
+
+	return g, nil
+}
+
+// writeDigraph6Size writes N(n): n+63 as a single byte for n<63, or 126
+// followed by a 3-byte big-endian encoding (each byte holding 6 bits,
+// biased by 63) for larger n.
+func writeDigraph6Size(w *bufio.Writer, n int) error {
+	if n < 0 {
+		return fmt.Errorf("happytree: invalid vertex count %d", n)
+	}
+	if n <= 62 {
+		return w.WriteByte(byte(n) + 63)
+	}
+	if n > maxDigraph6Size {
+		return fmt.Errorf("happytree: vertex count %d too large for the 3-byte digraph6 size", n)
+	}
+	if err := w.WriteByte(126); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{
+		byte((n>>12)&0x3F) + 63,
+		byte((n>>6)&0x3F) + 63,
+		byte(n&0x3F) + 63,
+	})
+	return err
+}
+
+// maxDigraph6Size is the largest vertex count the 3-byte N(n) encoding can
+// hold (1<<18 - 1), the same bound writeDigraph6Size enforces when writing.
+const maxDigraph6Size = 1<<18 - 1
+
+func readDigraph6Size(r *bufio.Reader) (int, error) {
+	b0, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b0 != 126 {
+		return decodeDigraph6SizeByte(b0)
+	}
+
+	var buf [3]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	hi, err := decodeDigraph6SizeByte(buf[0])
+	if err != nil {
+		return 0, err
+	}
+	mid, err := decodeDigraph6SizeByte(buf[1])
+	if err != nil {
+		return 0, err
+	}
+	lo, err := decodeDigraph6SizeByte(buf[2])
+	if err != nil {
+		return 0, err
+	}
+	return hi<<12 | mid<<6 | lo, nil
+}
+
+// decodeDigraph6SizeByte validates and un-biases a single digraph6 size
+// byte. Valid bytes are 63-126 (0-63 once the bias writeDigraph6Size added
+// is removed); anything else isn't a byte writeDigraph6Size could have
+// produced, biased or not, and bounds-checking the composed multi-byte
+// size afterward wouldn't catch it - an out-of-range byte can still OR
+// together into an in-range result.
+func decodeDigraph6SizeByte(b byte) (int, error) {
+	if b < 63 || b > 126 {
+		return 0, fmt.Errorf("happytree: invalid digraph6 size byte %d", b)
+	}
+	return int(b) - 63, nil
+}
+
+// bitWriter packs bits written one at a time into 6-bit groups, flushing
+// each as a byte biased by 63 - the unit digraph6 packs its adjacency
+// matrix into.
+type bitWriter struct {
+	w     *bufio.Writer
+	buf   byte
+	nbits int
+}
+
+func newBitWriter(w *bufio.Writer) *bitWriter {
+	return &bitWriter{w: w}
+}
+
+func (b *bitWriter) writeBit(set bool) error {
+	b.buf <<= 1
+	if set {
+		b.buf |= 1
+	}
+	b.nbits++
+	if b.nbits == 6 {
+		return b.flushGroup()
+	}
+	return nil
+}
+
+func (b *bitWriter) flushGroup() error {
+	if b.nbits == 0 {
+		return nil
+	}
+	b.buf <<= uint(6 - b.nbits)
+	err := b.w.WriteByte(b.buf + 63)
+	b.buf, b.nbits = 0, 0
+	return err
+}
+
+func (b *bitWriter) flush() error {
+	return b.flushGroup()
+}
+
+// bitReader is bitWriter's inverse: it reads 6-bit groups as they're needed
+// one bit at a time.
+type bitReader struct {
+	r     *bufio.Reader
+	buf   byte
+	nbits int
+}
+
+func newBitReader(r *bufio.Reader) *bitReader {
+	return &bitReader{r: r}
+}
+
+func (b *bitReader) readBit() (bool, error) {
+	if b.nbits == 0 {
+		raw, err := b.r.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		b.buf = raw - 63
+		b.nbits = 6
+	}
+	b.nbits--
+	return (b.buf>>uint(b.nbits))&1 == 1, nil
+}
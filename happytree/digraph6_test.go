@@ -0,0 +1,66 @@
+package happytree
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecodeDigraph6RoundTrip(t *testing.T) {
+	g := newTestGraph([]int32{3, 3, 2, 2, 0})
+
+	var buf bytes.Buffer
+	if err := EncodeDigraph6(&buf, g); err != nil {
+		t.Fatalf("EncodeDigraph6: %v", err)
+	}
+
+	got, err := DecodeDigraph6(&buf)
+	if err != nil {
+		t.Fatalf("DecodeDigraph6: %v", err)
+	}
+
+	if got.Len() != g.Len() {
+		t.Fatalf("Len() = %d, want %d", got.Len(), g.Len())
+	}
+	for i := int32(0); i < int32(g.Len()); i++ {
+		if got.Dst(i) != g.Dst(i) {
+			t.Errorf("Dst(%d) = %d, want %d", i, got.Dst(i), g.Dst(i))
+		}
+		if !equalInt32(got.Srcs(i), g.Srcs(i)) {
+			t.Errorf("Srcs(%d) = %v, want %v", i, got.Srcs(i), g.Srcs(i))
+		}
+	}
+}
+
+func TestDecodeDigraph6RejectsBadHeader(t *testing.T) {
+	_, err := DecodeDigraph6(bytes.NewReader([]byte("not digraph6")))
+	if err == nil {
+		t.Fatal("expected an error for a non-digraph6 stream")
+	}
+}
+
+func TestDecodeDigraph6RejectsOutOfRangeSize(t *testing.T) {
+	// '&' header followed by a size byte below 63, which decodes to a
+	// negative vertex count.
+	_, err := DecodeDigraph6(bytes.NewReader([]byte{'&', 10}))
+	if err == nil {
+		t.Fatal("expected an error for an out-of-range vertex count")
+	}
+}
+
+func TestReadDigraph6SizeRejectsOutOfRangeBytes(t *testing.T) {
+	// A single size byte above 125 was never emitted by
+	// writeDigraph6Size (126 is the 3-byte escape, and n<=62 tops out
+	// at 125) and shouldn't silently decode as a larger n.
+	if _, err := readDigraph6Size(bufio.NewReader(bytes.NewReader([]byte{200}))); err == nil {
+		t.Fatal("expected an error for an out-of-range single size byte")
+	}
+
+	// 127 is out of the valid 63-126 range for a 3-byte group byte, but
+	// ORs together with the others into an in-range n; it must still be
+	// rejected rather than silently composed.
+	in := []byte{126, 63, 63, 127}
+	if _, err := readDigraph6Size(bufio.NewReader(bytes.NewReader(in))); err == nil {
+		t.Fatal("expected an error for an out-of-range 3-byte size byte")
+	}
+}
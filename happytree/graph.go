@@ -0,0 +1,221 @@
+package happytree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Node is the packed per-node record: three int32s (12 bytes) instead of a
+// {int; int; []int} - see NewGraph for why. A node's number isn't stored at
+// all; it's just the node's index into the Graph.
+type Node struct {
+	Dst    int32
+	srcOff int32
+	srcLen int32
+}
+
+// Graph is a freelist-backed packed representation of a happy-number
+// functional graph: Base and Size record how it was generated, nodes are
+// addressed by index, and each node's sources are a (offset, length) span
+// into a single contiguous arena rather than their own slice.
+type Graph struct {
+	Base int
+	Size int
+
+	nodes  []Node
+	srcs   []int32
+	cursor []int32 // per-node write head into srcs; only valid while building
+}
+
+// NewGraph builds the functional graph formed by mapping every integer in
+// [0, size) through HappifyColor in the given base. Building it is two
+// passes over the edges: one to record each node's destination and bump
+// its in-degree, one to place the sources once every span's offset is
+// known - a span has to be contiguous in the arena, so its start isn't
+// known until every earlier node's span length is.
+func NewGraph(base, size int) (*Graph, error) {
+	if base < 2 || base > 36 {
+		return nil, fmt.Errorf("happytree: base %d out of range [2, 36]", base)
+	}
+
+	g := &Graph{
+		Base:  base,
+		Size:  size,
+		nodes: make([]Node, size),
+		srcs:  make([]int32, size),
+	}
+
+	bits := channelBits(size)
+	dsts := make([]int32, size)
+	for i := 0; i < size; i++ {
+		dst := int32(HappifyColor(uint32(i), base, bits))
+		dsts[i] = dst
+		g.nodes[i].Dst = dst
+		g.nodes[dst].srcLen++
+	}
+
+	g.cursor = make([]int32, size)
+	var off int32
+	for i := range g.nodes {
+		g.nodes[i].srcOff = off
+		g.cursor[i] = off
+		off += g.nodes[i].srcLen
+	}
+
+	for i := 0; i < size; i++ {
+		dst := dsts[i]
+		g.srcs[g.cursor[dst]] = int32(i)
+		g.cursor[dst]++
+	}
+
+	return g, nil
+}
+
+func (g *Graph) Len() int { return len(g.nodes) }
+
+func (g *Graph) Dst(i int32) int32 { return g.nodes[i].Dst }
+
+// Srcs returns node i's sources as a slice into the shared arena. Like any
+// slice into shared backing storage, it's only valid until the Graph is
+// mutated again.
+func (g *Graph) Srcs(i int32) []int32 {
+	n := g.nodes[i]
+	return g.srcs[n.srcOff : n.srcOff+n.srcLen]
+}
+
+// CountSrcs returns the size of the subtree rooted at node i, including i
+// itself.
+func (g *Graph) CountSrcs(i int32) int {
+	c := 1
+	for _, si := range g.Srcs(i) {
+		c += g.CountSrcs(si)
+	}
+	return c
+}
+
+// Loop is a cycle in the functional graph, given as the sequence of node
+// numbers that make it up.
+type Loop []int32
+
+func (l Loop) String() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("[")
+	for i, n := range l {
+		if i > 0 {
+			buf.WriteString(" -> ")
+		}
+		fmt.Fprintf(buf, "%06X", n)
+	}
+	buf.WriteString("]")
+	return buf.String()
+}
+
+func isInSet(set Loop, i int32) bool {
+	for _, si := range set {
+		if si == i {
+			return true
+		}
+	}
+	return false
+}
+
+// FindLoops walks every node looking for cycles. The returned loops may
+// overlap and duplicate each other; pass them through DedupLoops before
+// relying on them being disjoint.
+func (g *Graph) FindLoops() []Loop {
+	var loops []Loop
+	loop := make(Loop, 0, 16)
+outerLoop:
+	for i := int32(0); i < int32(g.Len()); i++ {
+		// If i is part of any of the loops found so far, don't bother
+		for _, l := range loops {
+			if isInSet(l, i) {
+				continue outerLoop
+			}
+		}
+
+		if rloop := g.maybeLoop(i, loop); len(rloop) > 0 {
+			loops = append(loops, rloop)
+			loop = make(Loop, 0, 16)
+		}
+	}
+	return loops
+}
+
+func (g *Graph) maybeLoop(i int32, loop Loop) Loop {
+	origI := i
+	for {
+		loop = append(loop, i)
+
+		dst := g.Dst(i)
+		if dst == origI {
+			break
+		}
+
+		for _, ln := range loop {
+			if ln == dst {
+				return nil
+			}
+		}
+
+		i = dst
+	}
+
+	return loop
+}
+
+// DedupLoops drops any loop that shares a node with a loop earlier in the
+// slice.
+func DedupLoops(loops []Loop) []Loop {
+	found := map[int32]bool{}
+	ret := make([]Loop, 0, len(loops))
+outer:
+	for _, loop := range loops {
+		for _, i := range loop {
+			if found[i] {
+				continue outer
+			}
+			found[i] = true
+		}
+		ret = append(ret, loop)
+	}
+	return ret
+}
+
+// NodeLevels returns the depth of the subtree rooted at node i, not
+// counting into excluding (used to keep a loop from recursing into itself).
+func (g *Graph) NodeLevels(i int32, excluding Loop) int {
+	max := 0
+outerLoop:
+	for _, sni := range g.Srcs(i) {
+		for _, en := range excluding {
+			if en == sni {
+				continue outerLoop
+			}
+		}
+		if c := g.NodeLevels(sni, nil); c > max {
+			max = c
+		}
+	}
+
+	// Return +1 to include this level
+	return max + 1
+}
+
+func (g *Graph) LoopLevels(loop Loop) int {
+	max := 0
+	for _, ln := range loop {
+		if c := g.NodeLevels(ln, loop); c > max {
+			max = c
+		}
+	}
+	return max
+}
+
+func (g *Graph) TotalLevels(loops []Loop) int {
+	levels := 0
+	for _, loop := range loops {
+		levels += g.LoopLevels(loop)
+	}
+	return levels
+}
@@ -0,0 +1,114 @@
+package happytree
+
+import "testing"
+
+// newTestGraph builds a Graph directly from an explicit list of
+// destinations (dsts[i] is node i's single outgoing edge), the same way
+// NewGraph does internally, but without routing through HappifyColor - so
+// the packed-graph algorithms below can be tested against a shape chosen by
+// hand instead of whatever a given base happens to produce.
+func newTestGraph(dsts []int32) *Graph {
+	size := len(dsts)
+	g := &Graph{
+		Size:  size,
+		nodes: make([]Node, size),
+		srcs:  make([]int32, size),
+	}
+	for i, dst := range dsts {
+		g.nodes[i].Dst = dst
+		g.nodes[dst].srcLen++
+	}
+
+	cursor := make([]int32, size)
+	var off int32
+	for i := range g.nodes {
+		g.nodes[i].srcOff = off
+		cursor[i] = off
+		off += g.nodes[i].srcLen
+	}
+	for i, dst := range dsts {
+		g.srcs[cursor[dst]] = int32(i)
+		cursor[dst]++
+	}
+	return g
+}
+
+func TestGraphSrcsAndCountSrcs(t *testing.T) {
+	// 0 -> 3, 1 -> 3, 2 -> 2 (fixed point), 3 -> 2
+	g := newTestGraph([]int32{3, 3, 2, 2})
+
+	if got := g.Dst(0); got != 3 {
+		t.Errorf("Dst(0) = %d, want 3", got)
+	}
+
+	if got, want := g.Srcs(3), []int32{0, 1}; !equalInt32(got, want) {
+		t.Errorf("Srcs(3) = %v, want %v", got, want)
+	}
+
+	if got := g.CountSrcs(0); got != 1 {
+		t.Errorf("CountSrcs(0) = %d, want 1 (leaf)", got)
+	}
+	if got := g.CountSrcs(3); got != 3 {
+		t.Errorf("CountSrcs(3) = %d, want 3 (itself plus 0 and 1)", got)
+	}
+}
+
+func TestNewGraphRejectsOutOfRangeBase(t *testing.T) {
+	for _, base := range []int{1, 37} {
+		if _, err := NewGraph(base, 16); err == nil {
+			t.Errorf("NewGraph(%d, 16) succeeded, want error", base)
+		}
+	}
+}
+
+func TestFindLoopsAndDedupLoops(t *testing.T) {
+	// Two disjoint 2-cycles: 0<->1 and 2<->3.
+	g := newTestGraph([]int32{1, 0, 3, 2})
+
+	loops := DedupLoops(g.FindLoops())
+	if len(loops) != 2 {
+		t.Fatalf("got %d loops, want 2: %v", len(loops), loops)
+	}
+
+	var sets [][]int32
+	for _, l := range loops {
+		sets = append(sets, []int32(l))
+	}
+	if !(containsSet(sets, 0, 1) && containsSet(sets, 2, 3)) {
+		t.Errorf("loops = %v, want sets {0,1} and {2,3}", loops)
+	}
+}
+
+func TestLoopLevels(t *testing.T) {
+	// 0 <-> 1 is the loop; 2 -> 0 and 3 -> 2 hang a 2-deep subtree off it.
+	g := newTestGraph([]int32{1, 0, 0, 2})
+	loop := Loop{0, 1}
+
+	if got := g.LoopLevels(loop); got != 3 {
+		t.Errorf("LoopLevels(%v) = %d, want 3", loop, got)
+	}
+	if got := g.TotalLevels([]Loop{loop}); got != 3 {
+		t.Errorf("TotalLevels = %d, want 3", got)
+	}
+}
+
+func equalInt32(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsSet(sets [][]int32, a, b int32) bool {
+	for _, s := range sets {
+		if len(s) == 2 && ((s[0] == a && s[1] == b) || (s[0] == b && s[1] == a)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+// Package happytree computes and represents the functional graph formed by
+// iterating the happy-number digit-square-sum step over a range of
+// integers, in an arbitrary base. The fixed points and cycle structures
+// that fall out of this are well known to differ per base, which is the
+// whole point of letting callers pick one.
+package happytree
+
+import "strconv"
+
+// Happify returns one step of the happy-number iteration for n in the
+// given base: split n into its base-digit representation and sum the
+// squares of those digits.
+func Happify(n, base int) int {
+	s := strconv.FormatInt(int64(n), base)
+	sum := 0
+	for _, r := range s {
+		d := digitVal(r)
+		sum += d * d
+	}
+	return sum
+}
+
+// HappifyColor treats rgb as three equal-width color lanes, each bits wide,
+// and maps each one through Happify independently, in the given base. The
+// result is always < 1<<(3*bits), so callers that size a Graph's nodes off
+// of channelBits(size) are guaranteed a Dst within range.
+func HappifyColor(rgb uint32, base, bits int) uint32 {
+	mask := uint32(1)<<uint(bits) - 1
+	r := Happify(int((rgb>>uint(2*bits))&mask), base)
+	g := Happify(int((rgb>>uint(bits))&mask), base)
+	b := Happify(int(rgb&mask), base)
+	return (uint32(r)&mask)<<uint(2*bits) | (uint32(g)&mask)<<uint(bits) | (uint32(b) & mask)
+}
+
+// channelBits returns the widest per-channel bit width whose three lanes
+// still span no more than size values (1<<(3*bits) <= size), so that
+// NewGraph's HappifyColor(i, base, channelBits(size)) always lands within
+// [0, size) regardless of what size the caller asked for. The legacy
+// 0x1000000-node graph works out to 8 bits per channel, same as before
+// channel width tracked size.
+func channelBits(size int) int {
+	bits := 0
+	for 1<<uint(3*(bits+1)) <= size {
+		bits++
+	}
+	return bits
+}
+
+// digitVal converts a single digit rune, as produced by
+// strconv.FormatInt, into its numeric value. strconv uses '0'-'9' then
+// lowercase 'a'-'z' for bases above 10.
+func digitVal(r rune) int {
+	if r >= '0' && r <= '9' {
+		return int(r - '0')
+	}
+	return int(r-'a') + 10
+}
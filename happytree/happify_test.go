@@ -0,0 +1,48 @@
+package happytree
+
+import "testing"
+
+func TestHappify(t *testing.T) {
+	tests := []struct {
+		n, base, want int
+	}{
+		{0, 16, 0},
+		{1, 10, 1},     // fixed point
+		{4, 10, 16},    // first step of the classic base-10 unhappy loop
+		{16, 10, 37},   // second step of the same loop
+		{255, 16, 450}, // "ff" -> 15^2 + 15^2
+	}
+	for _, tt := range tests {
+		if got := Happify(tt.n, tt.base); got != tt.want {
+			t.Errorf("Happify(%d, %d) = %d, want %d", tt.n, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestHappifyColor(t *testing.T) {
+	if got, want := HappifyColor(0x010203, 16, 8), uint32(0x010409); got != want {
+		t.Errorf("HappifyColor(0x010203, 16, 8) = %#06x, want %#06x", got, want)
+	}
+}
+
+func TestChannelBits(t *testing.T) {
+	tests := []struct {
+		size, want int
+	}{
+		{0, 0},
+		{1, 0},
+		{7, 0},
+		{8, 1},
+		{20000, 4},
+		{0x1000000, 8},
+	}
+	for _, tt := range tests {
+		got := channelBits(tt.size)
+		if got != tt.want {
+			t.Errorf("channelBits(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+		if span := 1 << uint(3*got); tt.size > 0 && span > tt.size {
+			t.Errorf("channelBits(%d) = %d spans %d values, more than size", tt.size, got, span)
+		}
+	}
+}
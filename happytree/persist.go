@@ -0,0 +1,111 @@
+package happytree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Save writes the graph as a length-prefixed binary dump: little-endian
+// int64s for Base, Size, and the node count, then the packed nodes, then a
+// length-prefixed srcs arena. Loading it back is a handful of io.ReadFull
+// calls instead of a reflection-driven gob decode.
+//
+// The nodes are packed by hand with writeNodes rather than handed to
+// encoding/binary directly: Node's srcOff/srcLen fields are unexported, and
+// while binary.Write can read an unexported field via reflection, the
+// corresponding binary.Read cannot set one back - it panics.
+func (g *Graph) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	header := []int64{int64(g.Base), int64(g.Size), int64(len(g.nodes))}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if err := writeNodes(w, g.nodes); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int64(len(g.srcs))); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, g.srcs); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// LoadGraph reads back what Save wrote.
+func LoadGraph(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	var base, size, numNodes int64
+	for _, v := range []*int64{&base, &size, &numNodes} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+
+	nodes, err := readNodes(r, numNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var numSrcs int64
+	if err := binary.Read(r, binary.LittleEndian, &numSrcs); err != nil {
+		return nil, err
+	}
+	srcs := make([]int32, numSrcs)
+	if err := binary.Read(r, binary.LittleEndian, srcs); err != nil {
+		return nil, err
+	}
+
+	return &Graph{Base: int(base), Size: int(size), nodes: nodes, srcs: srcs}, nil
+}
+
+// nodeSize is the on-disk width of one Node: three little-endian int32s.
+const nodeSize = 12
+
+// writeNodes packs nodes field-by-field instead of going through
+// encoding/binary's reflection path, which would otherwise be the only
+// difference between this and the srcs arena just above it.
+func writeNodes(w io.Writer, nodes []Node) error {
+	buf := make([]byte, nodeSize*len(nodes))
+	for i, n := range nodes {
+		off := i * nodeSize
+		binary.LittleEndian.PutUint32(buf[off:], uint32(n.Dst))
+		binary.LittleEndian.PutUint32(buf[off+4:], uint32(n.srcOff))
+		binary.LittleEndian.PutUint32(buf[off+8:], uint32(n.srcLen))
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readNodes reads back what writeNodes wrote.
+func readNodes(r io.Reader, numNodes int64) ([]Node, error) {
+	buf := make([]byte, nodeSize*numNodes)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, numNodes)
+	for i := range nodes {
+		off := i * nodeSize
+		nodes[i].Dst = int32(binary.LittleEndian.Uint32(buf[off:]))
+		nodes[i].srcOff = int32(binary.LittleEndian.Uint32(buf[off+4:]))
+		nodes[i].srcLen = int32(binary.LittleEndian.Uint32(buf[off+8:]))
+	}
+	return nodes, nil
+}
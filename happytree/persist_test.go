@@ -0,0 +1,36 @@
+package happytree
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoadGraphRoundTrip(t *testing.T) {
+	g := newTestGraph([]int32{3, 3, 2, 2, 0})
+	g.Base = 16
+
+	path := filepath.Join(t.TempDir(), "nodes.bin")
+	if err := g.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := LoadGraph(path)
+	if err != nil {
+		t.Fatalf("LoadGraph: %v", err)
+	}
+
+	if got.Base != g.Base {
+		t.Errorf("Base = %d, want %d", got.Base, g.Base)
+	}
+	if got.Len() != g.Len() {
+		t.Fatalf("Len() = %d, want %d", got.Len(), g.Len())
+	}
+	for i := int32(0); i < int32(g.Len()); i++ {
+		if got.Dst(i) != g.Dst(i) {
+			t.Errorf("Dst(%d) = %d, want %d", i, got.Dst(i), g.Dst(i))
+		}
+		if !equalInt32(got.Srcs(i), g.Srcs(i)) {
+			t.Errorf("Srcs(%d) = %v, want %v", i, got.Srcs(i), g.Srcs(i))
+		}
+	}
+}
@@ -1,115 +1,37 @@
 package main
 
 import (
-	"bytes"
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"log"
 	"math"
 	"os"
 	"os/signal"
+	"runtime"
 	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mediocregopher/happy-tree/happytree"
 )
 
 func init() {
 	go drawCounter()
 }
 
-const (
-	numNodes  = 0x1000000
-	nodesFile = "nodes.gob"
-	loopsFile = "loops.gob"
-)
-
-type Node struct {
-	Num  int
-	Dst  int
-	Srcs []int
-}
-
-func (n Node) String() string {
-	return fmt.Sprintf("{%06X -> %06X (%d srcs)}", n.Num, n.Dst, len(n.Srcs))
-}
-
-type Nodes []Node
-
-func (n Nodes) String() string {
-	buf := new(bytes.Buffer)
-	buf.WriteString("[")
-	if len(n) > 0 {
-		buf.WriteString("\n")
-	}
-	for i := range n {
-		buf.WriteString(fmt.Sprintf("\t%v\n", n[i]))
-	}
-	buf.WriteString("]")
-	return buf.String()
-}
+const defaultSize = 0x1000000
 
-var charToDec = map[rune]int{
-	'0': 0,
-	'1': 1,
-	'2': 2,
-	'3': 3,
-	'4': 4,
-	'5': 5,
-	'6': 6,
-	'7': 7,
-	'8': 8,
-	'9': 9,
-	'A': 10,
-	'B': 11,
-	'C': 12,
-	'D': 13,
-	'E': 14,
-	'F': 15,
-}
-
-func happify(i int) int {
-	s := fmt.Sprintf("%X", i)
-	dst := 0
-	for _, r := range s {
-		ri := charToDec[r]
-		dst += ri * ri
-	}
-	return dst
-}
-
-func happifyColor(i int) int {
-	r := happify(i & 0xFF0000)
-	g := happify(i & 0x00FF00)
-	b := happify(i & 0x0000FF)
-	return ((r & 0xFF) << 16) | ((g & 0xFF) << 8) | (b & 0xFF)
-}
-
-func countSrcs(n Nodes, nn Node) int {
-	c := 1
-	for _, si := range nn.Srcs {
-		c += countSrcs(n, n[si])
-	}
-	return c
-}
-
-func isInSet(n Nodes, i int) bool {
-	for _, nn := range n {
-		if nn.Num == i {
+func isInSet(set happytree.Loop, i int32) bool {
+	for _, si := range set {
+		if si == i {
 			return true
 		}
 	}
 	return false
 }
 
-func createNodes() Nodes {
-	n := make(Nodes, numNodes)
-	for i := range n {
-		dst := happifyColor(i)
-		n[i].Num = i
-		n[i].Dst = dst
-		n[dst].Srcs = append(n[dst].Srcs, i)
-	}
-	return n
-}
-
 func store(n interface{}, path string) error {
 	f, err := os.Create(path)
 	if err != nil {
@@ -132,185 +54,196 @@ func load(n interface{}, path string) error {
 	return dec.Decode(n)
 }
 
-func findLoops(n Nodes) []Nodes {
-	var loops []Nodes
-	loop := make(Nodes, 0, 16)
-outerLoop:
-	for i := 0; i < numNodes; i++ {
-		// If i is part of any of the loops found so far, don't bother
-		for i := range loops {
-			if isInSet(loop, i) {
-				continue outerLoop
-			}
-		}
+// drawCount is incremented with a plain atomic add instead of a channel send
+// so drawing itself never blocks on the counter; drawCounter just samples it
+// periodically for progress logging.
+var drawCount int64
 
-		if rloop := maybeLoop(n, i, loop); len(rloop) > 0 {
-			loops = append(loops, rloop)
-			loop = make(Nodes, 0, 16)
-		}
-	}
-	return loops
-}
-
-func maybeLoop(n Nodes, i int, loop Nodes) Nodes {
-	origI := i
-	for {
-		loop = append(loop, n[i])
-
-		dst := n[i].Dst
-		if dst == origI {
-			break
-		}
+// this is started in its own go-routine in init
+func drawCounter() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-		for _, ln := range loop {
-			if ln.Num == dst {
-				return nil
-			}
+	var lastLogged int64
+	for range ticker.C {
+		total := atomic.LoadInt64(&drawCount)
+		if total/0x10000 != lastLogged/0x10000 {
+			log.Printf("drawn: %06X", total)
 		}
-
-		i = dst
+		lastLogged = total
 	}
-
-	return loop
 }
 
-func dedupLoops(loops []Nodes) []Nodes {
-	found := map[int]bool{}
-	ret := make([]Nodes, 0, len(loops))
-outer:
-	for _, loop := range loops {
-		for _, n := range loop {
-			if found[n.Num] {
-				continue outer
-			}
-			found[n.Num] = true
-		}
-		ret = append(ret, loop)
+// drawOne draws c into i and bumps drawCount. It uses i's concurrent-safe
+// path when the backend has one (pngImg's tiles), since drawNode may be
+// running in several goroutines at once against the same img.
+func drawOne(i img, c curve) {
+	if ti, ok := i.(tiledImg); ok {
+		ti.drawCurveConcurrent(c)
+	} else {
+		i.drawCurve(c)
 	}
-	return ret
+	atomic.AddInt64(&drawCount, 1)
 }
 
-func nodeLevels(n Nodes, nn Node, excluding Nodes) int {
-	max := 0
-outerLoop:
-	for _, sni := range nn.Srcs {
-		for _, en := range excluding {
-			if en.Num == sni {
-				continue outerLoop
-			}
-		}
-		if c := nodeLevels(n, n[sni], nil); c > max {
-			max = c
+// srcCounts returns, for each of i's non-excluded sources, the size of the
+// subtree rooted there, plus their sum. drawNode and drawNodeParallel use
+// these to split the arc [start, end) proportionally between branches.
+func srcCounts(g *happytree.Graph, i int32, excluding happytree.Loop) ([]int, int) {
+	srcs := g.Srcs(i)
+	counts := make([]int, len(srcs))
+	total := 0
+	for j, si := range srcs {
+		if isInSet(excluding, si) {
+			continue
 		}
+		c := g.CountSrcs(si)
+		counts[j] = c
+		total += c
 	}
+	return counts, total
+}
 
-	// Return +1 to include this level
-	return max + 1
+// renderCtx bundles the parameters that stay constant across one
+// drawLoopParallel call's recursion: the graph and image being drawn into,
+// the color mapper, and the totals a mapper needs to normalize level/loopIdx
+// against.
+type renderCtx struct {
+	g           *happytree.Graph
+	i           img
+	mapper      ColorMapper
+	totalLevels int
+	loopIdx     int
 }
 
-func loopLevels(n Nodes, loop Nodes) int {
-	max := 0
-	for _, ln := range loop {
-		if c := nodeLevels(n, ln, loop); c > max {
-			max = c
+// drawNode draws nn and its non-excluded subtree into rc serially in the
+// calling goroutine. sem is still acquired around each draw, even though
+// nothing else is running concurrently with it here, because drawNode is
+// also reached from drawNodeParallel past drawForkDepth - at that point
+// several of these serial recursions are running in their own goroutines,
+// and sem is what keeps them from calling a non-concurrent-safe backend's
+// drawCurve (svg, pdf) at the same time.
+func drawNode(sem chan struct{}, rc renderCtx, nn int32, excluding happytree.Loop, level int, start, end float64) {
+	counts, total := srcCounts(rc.g, nn, excluding)
+	subtreeSize := total + 1 // +1 for nn itself
+
+	sem <- struct{}{}
+	drawOne(rc.i, curve{level: level, color: rc.mapper.Color(nn, level, rc.totalLevels, rc.loopIdx, subtreeSize), start: start, end: end})
+	<-sem
+
+	diff := end - start
+	for j, sni := range rc.g.Srcs(nn) {
+		if isInSet(excluding, sni) {
+			continue
 		}
-	}
-	return max
-}
+		fract := (float64(counts[j]) / float64(total)) * diff
 
-func totalLevels(n Nodes, loops []Nodes) int {
-	levels := 0
-	for _, loop := range loops {
-		levels += loopLevels(n, loop)
+		drawNode(sem, rc, sni, nil, level+1, start, start+fract)
+		start += fract
 	}
-	return levels
 }
 
-var drawCountCh = make(chan bool)
-
-// this is started in its own go-routine in init
-func drawCounter() {
-	total := 0
-	for _ = range drawCountCh {
-		total++
-		if total%0x10000 == 0 {
-			log.Printf("drawn: %06X", total)
-		}
-	}
+// drawForkDepth is the ring level (curve.level) up to which drawNodeParallel
+// forks its children into their own goroutines; past it, drawNode recurses
+// serially in whichever goroutine reached it. Ring levels keep counting
+// outward across loops, so a loop whose first ring lies past drawForkDepth
+// forks only per loop member, in drawLoopParallel. The happy-number loops are shallow compared to the
+// node subtrees hanging off them, so a handful of forked levels is enough
+// to keep every worker busy.
+const drawForkDepth = 6
+
+// newDrawSem returns the semaphore drawNodeParallel uses to cap how many
+// curves are drawn at once. A goroutine only ever holds a slot for the
+// duration of its own draw call, never while waiting on its children's
+// WaitGroup, so a branch blocked on its descendants can't starve the slots
+// those descendants need to make progress - unlike a fixed-size worker pool
+// pulling work off a shared queue, where a worker blocked on a descendant
+// can leave that very descendant stuck undrained.
+func newDrawSem(i img) chan struct{} {
+	return make(chan struct{}, numDrawWorkers(i))
 }
 
-func drawNode(n Nodes, i img, nn Node, excluding Nodes, level int, start, end float64) {
-	c := curve{
-		level: level,
-		color: nn.Num,
-		start: start,
-		end:   end,
+// drawNodeParallel is drawNode's forking counterpart: each non-excluded
+// branch (down to drawForkDepth) runs in its own goroutine, with sem
+// bounding how many curves are drawn concurrently.
+func drawNodeParallel(sem chan struct{}, rc renderCtx, nn int32, excluding happytree.Loop, level int, start, end float64) {
+	if level > drawForkDepth {
+		drawNode(sem, rc, nn, excluding, level, start, end)
+		return
 	}
-	i.drawCurve(c)
-	drawCountCh <- true
 
-	srcCounts := make([]int, len(nn.Srcs))
-	srcTotal := 0
-	for j, sni := range nn.Srcs {
-		if isInSet(excluding, sni) {
-			continue
-		}
-		c := countSrcs(n, n[sni])
-		srcCounts[j] = c
-		srcTotal += c
-	}
+	counts, total := srcCounts(rc.g, nn, excluding)
+	subtreeSize := total + 1 // +1 for nn itself
 
-	diff := end - start
-	for j, sni := range nn.Srcs {
-		sn := n[sni]
+	sem <- struct{}{}
+	drawOne(rc.i, curve{level: level, color: rc.mapper.Color(nn, level, rc.totalLevels, rc.loopIdx, subtreeSize), start: start, end: end})
+	<-sem
 
+	diff := end - start
+	var wg sync.WaitGroup
+	for j, sni := range rc.g.Srcs(nn) {
 		if isInSet(excluding, sni) {
 			continue
 		}
-
-		fract := (float64(srcCounts[j]) / float64(srcTotal)) * diff
-
-		drawNode(n, i, sn, nil, level+1, start, start+fract)
+		fract := (float64(counts[j]) / float64(total)) * diff
+		branchStart, branchEnd := start, start+fract
 		start += fract
+
+		wg.Add(1)
+		go func(sni int32, branchStart, branchEnd float64) {
+			defer wg.Done()
+			drawNodeParallel(sem, rc, sni, nil, level+1, branchStart, branchEnd)
+		}(sni, branchStart, branchEnd)
 	}
+	wg.Wait()
 }
 
-type drawNodeCmd struct {
-	n          Nodes
-	i          img
-	nn         Node
-	excluding  Nodes
-	level      int
-	start, end float64
-	done       chan struct{}
+// numDrawWorkers returns how many curves may be drawn concurrently. Only
+// tiledImg backends (pngImg) are safe to draw into from more than one
+// goroutine at once.
+func numDrawWorkers(i img) int {
+	if _, ok := i.(tiledImg); ok {
+		return runtime.NumCPU()
+	}
+	return 1
 }
 
-func drawLoop(n Nodes, i img, loop Nodes, level int) {
-	// We do this this way instead of just doing a countSrcs on each loop node
+// drawLoopParallel draws one loop and everything hanging off it: each loop
+// member's branch runs in its own goroutine via drawNodeParallel.
+func drawLoopParallel(sem chan struct{}, rc renderCtx, loop happytree.Loop, level int) {
+	// We do this this way instead of just doing a CountSrcs on each loop node
 	// directly because we don't want to actually include the count from one of
 	// the loop nodes
 	srcTotal := 0
 	srcCounts := make([]int, len(loop))
 	for j, ln := range loop {
-		for _, sni := range ln.Srcs {
+		for _, sni := range rc.g.Srcs(ln) {
 			if isInSet(loop, sni) {
 				continue
 			}
-			c := countSrcs(n, n[sni])
+			c := rc.g.CountSrcs(sni)
 			srcCounts[j] += c
 			srcTotal += c
 		}
 	}
 
+	var wg sync.WaitGroup
 	start := float64(0)
 	for j, ln := range loop {
 		fract := float64(srcCounts[j]) / float64(srcTotal)
 		if math.IsNaN(fract) {
 			fract = 1
 		}
-		drawNode(n, i, ln, loop, level, start, start+fract)
+		branchStart, branchEnd := start, start+fract
 		start += fract
+
+		wg.Add(1)
+		go func(ln int32, branchStart, branchEnd float64) {
+			defer wg.Done()
+			drawNodeParallel(sem, rc, ln, loop, level, branchStart, branchEnd)
+		}(ln, branchStart, branchEnd)
 	}
+	wg.Wait()
 }
 
 func profileCPU() {
@@ -332,82 +265,93 @@ func profileCPU() {
 }
 
 func main() {
-	//j := newImg("test.png", 1000, 1000, 6)
-	//j.drawCurve(curve{
-	//	level: 5,
-	//	color: 0xFF00ff,
-	//	start: 0, end: 1,
-	//})
-	//j.drawCurve(curve{
-	//	level: 4,
-	//	color: 0xFF0000,
-	//	start: 0, end: 0.5,
-	//})
-	//j.drawCurve(curve{
-	//	level: 3,
-	//	color: 0x0000FF,
-	//	start: 0.5, end: 1,
-	//})
-	//j.drawCurve(curve{
-	//	level: 2,
-	//	color: 0x00FF00,
-	//	start: 0.25, end: 0.75,
-	//})
-	//j.drawCurve(curve{
-	//	level: 1,
-	//	color: 0xFFFF00,
-	//	start: 0, end: 0.66,
-	//})
-	//j.save()
-
-	//return
-
-	//log.Print("creating nodes")
-	//nodes := createNodes()
-	//log.Printf("total nodes: %X", len(nodes))
-
-	//log.Print("storing nodes")
-	//if err := store(&nodes, nodesFile); err != nil {
-	//	log.Fatal(err)
-	//}
-
-	log.Print("loading in nodes")
-	var nodes Nodes
-	if err := load(&nodes, nodesFile); err != nil {
-		log.Fatal(err)
-	}
-
-	//log.Print("finding loops")
-	//loops := findLoops(nodes)
-	//log.Printf("total loops (pr-dedup): %d", len(loops))
-
-	//log.Printf("deduplicating loops")
-	//loops = dedupLoops(loops)
+	base := flag.Int("base", 16, "numeric base to compute happy numbers in")
+	size := flag.Int("size", defaultSize, "number of nodes to generate")
+	out := flag.String("out", "happy-tree.png", "output file; backend is picked by extension (.png, .svg, .pdf)")
+	in := flag.String("in", "", "decode a digraph6-encoded functional graph from this file instead of generating/loading one; skips node generation entirely")
+	palette := flag.String("palette", "identity", "color mapping strategy: identity, hsl, loop, or a path to a .ggr/.json gradient file")
+	flag.Parse()
+
+	nodesFile := fmt.Sprintf("nodes-b%d-s%x.bin", *base, *size)
+	loopsFile := fmt.Sprintf("loops-b%d-s%x.gob", *base, *size)
+
+	var graph *happytree.Graph
+	if *in != "" {
+		log.Printf("decoding graph from %s", *in)
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		graph, err = happytree.DecodeDigraph6(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Print("loading in nodes")
+		var err error
+		graph, err = happytree.LoadGraph(nodesFile)
+		if os.IsNotExist(err) {
+			log.Print("creating graph")
+			graph, err = happytree.NewGraph(*base, *size)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("total nodes: %X", graph.Len())
 
-	//log.Printf("storing loops")
-	//if err := store(&loops, loopsFile); err != nil {
-	//	log.Fatal(err)
-	//}
+			log.Print("storing nodes")
+			if err := graph.Save(nodesFile); err != nil {
+				log.Fatal(err)
+			}
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	log.Print("loading in loops")
-	var loops []Nodes
-	if err := load(&loops, loopsFile); err != nil {
-		log.Fatal(err)
+	var loops []happytree.Loop
+	if *in != "" {
+		// loopsFile is keyed off -base/-size, which don't describe a graph
+		// decoded from -in; find the loops directly instead of reaching for
+		// a cache computed for an unrelated graph.
+		log.Print("finding loops")
+		loops = happytree.DedupLoops(graph.FindLoops())
+	} else {
+		log.Print("loading in loops")
+		err := load(&loops, loopsFile)
+		if os.IsNotExist(err) {
+			log.Print("finding loops")
+			loops = happytree.DedupLoops(graph.FindLoops())
+
+			log.Print("storing loops")
+			if err := store(&loops, loopsFile); err != nil {
+				log.Fatal(err)
+			}
+		} else if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	log.Printf("loops: %v", loops)
 	log.Printf("total loops: %d", len(loops))
 
-	levels := totalLevels(nodes, loops) + 1 // plus 1 because we start on level 1
+	levels := graph.TotalLevels(loops) + 1 // plus 1 because we start on level 1
 	log.Printf("totalLevels: %d", levels)
 
+	mapper, err := loadColorMapper(*palette, len(loops), graph.Len())
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	profileCPU()
 
-	i := newImg("happy-tree.png", 5000, 5000, levels)
+	i := newImg(*out, 5000, 5000, levels)
+	sem := newDrawSem(i)
+
 	level := 1
-	for _, loop := range loops {
-		drawLoop(nodes, i, loop, level)
-		level += loopLevels(nodes, loop)
+	for idx, loop := range loops {
+		rc := renderCtx{g: graph, i: i, mapper: mapper, totalLevels: levels, loopIdx: idx}
+		drawLoopParallel(sem, rc, loop, level)
+		level += graph.LoopLevels(loop)
 		level++
 	}
 